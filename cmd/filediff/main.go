@@ -0,0 +1,122 @@
+// Command filediff demonstrates the sig/delta/patch sync protocol from the filediff package over stdin/stdout
+// pipes, the way a real sender and receiver would run it as two separate processes connected by a network socket:
+//
+//	receiver$ filediff sig original.bin > sig.bin
+//	receiver$ scp sig.bin sender:
+//	sender$   filediff delta sig.bin updated.bin > delta.bin
+//	sender$   scp delta.bin receiver:
+//	receiver$ filediff patch sig.bin original.bin < delta.bin > output.bin
+package main
+
+import (
+	"eigertest/filediff"
+	"fmt"
+	"os"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "sig":
+		err = runSig(os.Args[2:])
+	case "delta":
+		err = runDelta(os.Args[2:])
+	case "patch":
+		err = runPatch(os.Args[2:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "filediff:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage:")
+	fmt.Fprintln(os.Stderr, "  filediff sig <original>            > sig.bin")
+	fmt.Fprintln(os.Stderr, "  filediff delta <sig.bin> <updated>  > delta.bin")
+	fmt.Fprintln(os.Stderr, "  filediff patch <sig.bin> <original> < delta.bin > output")
+}
+
+// runSig computes the signature of an original file and writes it to stdout.
+func runSig(args []string) error {
+	if len(args) != 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	original, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer original.Close()
+
+	sig, err := filediff.NewSignature(original)
+	if err != nil {
+		return fmt.Errorf("computing signature: %w", err)
+	}
+
+	return sig.EncodeTo(os.Stdout)
+}
+
+// runDelta computes a delta from a signature and an updated file, and writes it to stdout.
+func runDelta(args []string) error {
+	if len(args) != 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	sigFile, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer sigFile.Close()
+
+	sig, err := filediff.DecodeSignature(sigFile)
+	if err != nil {
+		return fmt.Errorf("decoding signature: %w", err)
+	}
+
+	updated, err := os.Open(args[1])
+	if err != nil {
+		return err
+	}
+	defer updated.Close()
+
+	return filediff.Delta(sig, updated, os.Stdout)
+}
+
+// runPatch applies a delta, read from stdin, against an original file, and writes the result to stdout.
+func runPatch(args []string) error {
+	if len(args) != 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	sigFile, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer sigFile.Close()
+
+	sig, err := filediff.DecodeSignature(sigFile)
+	if err != nil {
+		return fmt.Errorf("decoding signature: %w", err)
+	}
+
+	original, err := os.Open(args[1])
+	if err != nil {
+		return err
+	}
+	defer original.Close()
+
+	return filediff.Patch(sig, original, os.Stdin, os.Stdout)
+}
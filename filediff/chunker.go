@@ -0,0 +1,92 @@
+package filediff
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"eigertest/rollinghash"
+	"io"
+)
+
+// Chunking parameters for the content-defined chunker. A cut point is
+// declared once the low chunkMaskBits bits of the rolling hash over the
+// trailing chunkWindow bytes are all zero, which happens on average every
+// avgChunkSize bytes - the same masked-rolling-hash scheme container image
+// tooling uses to split layers into reusable, content-addressed blocks.
+// minChunkSize/maxChunkSize clamp the result so that degenerate input (long
+// runs of a repeated byte, for example) can't produce pathological chunks.
+const (
+	chunkWindow   = 48
+	chunkMaskBits = 13 // 2^13 = 8KiB average chunk size
+	avgChunkSize  = 1 << chunkMaskBits
+	minChunkSize  = avgChunkSize / 4
+	maxChunkSize  = avgChunkSize * 8
+	chunkMask     = avgChunkSize - 1
+)
+
+// chunk describes one content-defined block of a file: its byte range in
+// that file, the rolling (weak) hash at its cut point, and a strong hash
+// of its contents. The weak hash lets a matcher cheaply narrow down
+// candidates; the strong hash confirms a candidate really is the same
+// bytes rather than a weak-hash collision.
+type chunk struct {
+	Offset   int64
+	Length   int64
+	WeakHash uint32
+	Hash     [32]byte
+}
+
+// splitChunks reads r to EOF and splits it into variable-size,
+// content-defined chunks. Because cut points are determined by a rolling
+// hash of the surrounding bytes rather than a fixed byte count, an
+// insertion or deletion only perturbs the chunks touching it - every other
+// chunk in the file still lines up with its counterpart in the other file.
+func splitChunks(r io.Reader) ([]chunk, error) {
+	br := bufio.NewReader(r)
+	rh := rollinghash.NewBuzhash(chunkWindow)
+
+	var chunks []chunk
+	var window bytes.Buffer
+	var offset int64
+
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+
+		window.WriteByte(b)
+		hash := rh.Roll(b)
+
+		if atChunkBoundary(window.Len(), hash) {
+			chunks = append(chunks, newChunk(offset, window.Bytes(), hash))
+			offset += int64(window.Len())
+			window.Reset()
+			rh.Reset()
+		}
+	}
+
+	if window.Len() > 0 {
+		chunks = append(chunks, newChunk(offset, window.Bytes(), rh.Sum()))
+	}
+
+	return chunks, nil
+}
+
+// atChunkBoundary reports whether the current window length and rolling
+// hash value mark a chunk cut point.
+func atChunkBoundary(windowLen int, hash uint32) bool {
+	if windowLen >= maxChunkSize {
+		return true
+	}
+	return windowLen >= minChunkSize && hash&chunkMask == 0
+}
+
+func newChunk(offset int64, data []byte, weakHash uint32) chunk {
+	buf := make([]byte, len(data))
+	copy(buf, data)
+	return chunk{Offset: offset, Length: int64(len(buf)), WeakHash: weakHash, Hash: sha256.Sum256(buf)}
+}
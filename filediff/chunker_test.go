@@ -0,0 +1,67 @@
+package filediff
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// TestSplitChunksReassembles verifies that concatenating the chunks
+// produced by splitChunks reproduces the original bytes exactly.
+func TestSplitChunksReassembles(t *testing.T) {
+	data := make([]byte, avgChunkSize*10)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	chunks, err := splitChunks(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("splitChunks returned error: %v", err)
+	}
+
+	var reassembled bytes.Buffer
+	for _, c := range chunks {
+		reassembled.Write(data[c.Offset : c.Offset+c.Length])
+	}
+
+	if !bytes.Equal(reassembled.Bytes(), data) {
+		t.Errorf("reassembled chunks do not match original data")
+	}
+}
+
+// TestSplitChunksStableUnderInsertion checks the defining property of
+// content-defined chunking: inserting a few bytes near the start of the
+// data should leave most chunk boundaries - and therefore most chunk
+// hashes - unchanged, unlike fixed-size blocking.
+func TestSplitChunksStableUnderInsertion(t *testing.T) {
+	data := make([]byte, avgChunkSize*20)
+	rand.New(rand.NewSource(2)).Read(data)
+
+	original, err := splitChunks(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("splitChunks returned error: %v", err)
+	}
+
+	inserted := append([]byte{}, data[:100]...)
+	inserted = append(inserted, []byte("extra bytes inserted here")...)
+	inserted = append(inserted, data[100:]...)
+
+	modified, err := splitChunks(bytes.NewReader(inserted))
+	if err != nil {
+		t.Fatalf("splitChunks returned error: %v", err)
+	}
+
+	originalHashes := make(map[[32]byte]bool, len(original))
+	for _, c := range original {
+		originalHashes[c.Hash] = true
+	}
+
+	shared := 0
+	for _, c := range modified {
+		if originalHashes[c.Hash] {
+			shared++
+		}
+	}
+
+	if shared < len(original)/2 {
+		t.Errorf("expected most chunks to survive a small insertion, only %d of %d matched", shared, len(original))
+	}
+}
@@ -0,0 +1,227 @@
+package filediff
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Binary framing for a serialized delta stream:
+//
+//	magic (4 bytes)     "FDLT"
+//	version (1 byte)    deltaFormatVersion
+//	command* until EOF:
+//	  tag (1 byte)      commandTagCopy or commandTagInsert
+//	  position (varint) destination offset in the output file
+//	  copy:   offset (varint), length (varint), strong hash (32 raw bytes)
+//	  insert: data length (uvarint), data (that many raw bytes)
+//
+// Every field is length-prefixed or fixed-size, so - similarly to how a
+// simple LSM sstable entry is framed - a reader never needs to know a
+// command's total size up front; it can decode one command at a time
+// straight off the wire.
+const (
+	deltaMagic         = "FDLT"
+	deltaFormatVersion = 1
+
+	commandTagCopy   = 0
+	commandTagInsert = 1
+)
+
+// EncodeDelta writes cmds to w using the delta wire format, so a delta can be sent over a network or stored on disk
+// instead of only existing as an in-memory []DeltaCommand.
+func EncodeDelta(w io.Writer, cmds []DeltaCommand) error {
+	bw := bufio.NewWriter(w)
+
+	if _, err := bw.WriteString(deltaMagic); err != nil {
+		return err
+	}
+	if err := bw.WriteByte(deltaFormatVersion); err != nil {
+		return err
+	}
+
+	for _, cmd := range cmds {
+		if err := encodeCommand(bw, cmd); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+func encodeCommand(bw *bufio.Writer, cmd DeltaCommand) error {
+	var varintBuf [binary.MaxVarintLen64]byte
+
+	writeVarint := func(v int64) error {
+		n := binary.PutVarint(varintBuf[:], v)
+		_, err := bw.Write(varintBuf[:n])
+		return err
+	}
+
+	switch cmd.Command {
+	case "copy":
+		if err := bw.WriteByte(commandTagCopy); err != nil {
+			return err
+		}
+		if err := writeVarint(cmd.Position); err != nil {
+			return err
+		}
+		if err := writeVarint(cmd.Offset); err != nil {
+			return err
+		}
+		if err := writeVarint(cmd.Length); err != nil {
+			return err
+		}
+		_, err := bw.Write(cmd.Hash[:])
+		return err
+
+	case "insert":
+		if err := bw.WriteByte(commandTagInsert); err != nil {
+			return err
+		}
+		if err := writeVarint(cmd.Position); err != nil {
+			return err
+		}
+		n := binary.PutUvarint(varintBuf[:], uint64(len(cmd.Data)))
+		if _, err := bw.Write(varintBuf[:n]); err != nil {
+			return err
+		}
+		_, err := bw.Write(cmd.Data)
+		return err
+
+	default:
+		return fmt.Errorf("unknown command: %s", cmd.Command)
+	}
+}
+
+// DecodeDelta reads a full delta previously written by EncodeDelta.
+func DecodeDelta(r io.Reader) ([]DeltaCommand, error) {
+	br := bufio.NewReader(r)
+	if err := readDeltaHeader(br); err != nil {
+		return nil, err
+	}
+
+	var cmds []DeltaCommand
+	for {
+		cmd, err := decodeCommand(br)
+		if err != nil {
+			if err == io.EOF {
+				return cmds, nil
+			}
+			return nil, err
+		}
+		cmds = append(cmds, cmd)
+	}
+}
+
+// readDeltaHeader consumes and validates the magic and version prefix common to every delta stream.
+func readDeltaHeader(br *bufio.Reader) error {
+	magic := make([]byte, len(deltaMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return fmt.Errorf("reading delta magic: %w", err)
+	}
+	if string(magic) != deltaMagic {
+		return fmt.Errorf("not a delta stream: bad magic %q", magic)
+	}
+
+	version, err := br.ReadByte()
+	if err != nil {
+		return fmt.Errorf("reading delta version: %w", err)
+	}
+	if version != deltaFormatVersion {
+		return fmt.Errorf("unsupported delta format version %d", version)
+	}
+
+	return nil
+}
+
+// decodeCommand reads a single command off br. It returns io.EOF, unwrapped, when br is exhausted between commands
+// so callers can distinguish "clean end of stream" from a truncated one.
+func decodeCommand(br *bufio.Reader) (DeltaCommand, error) {
+	tag, err := br.ReadByte()
+	if err != nil {
+		return DeltaCommand{}, err // io.EOF included, unwrapped
+	}
+
+	position, err := binary.ReadVarint(br)
+	if err != nil {
+		return DeltaCommand{}, fmt.Errorf("reading position: %w", err)
+	}
+
+	switch tag {
+	case commandTagCopy:
+		offset, err := binary.ReadVarint(br)
+		if err != nil {
+			return DeltaCommand{}, fmt.Errorf("reading offset: %w", err)
+		}
+		length, err := binary.ReadVarint(br)
+		if err != nil {
+			return DeltaCommand{}, fmt.Errorf("reading length: %w", err)
+		}
+		var hash [32]byte
+		if _, err := io.ReadFull(br, hash[:]); err != nil {
+			return DeltaCommand{}, fmt.Errorf("reading strong hash: %w", err)
+		}
+		return DeltaCommand{Command: "copy", Position: position, Offset: offset, Length: length, Hash: hash}, nil
+
+	case commandTagInsert:
+		dataLen, err := binary.ReadUvarint(br)
+		if err != nil {
+			return DeltaCommand{}, fmt.Errorf("reading data length: %w", err)
+		}
+		data := make([]byte, dataLen)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return DeltaCommand{}, fmt.Errorf("reading data: %w", err)
+		}
+		return DeltaCommand{Command: "insert", Position: position, Data: data}, nil
+
+	default:
+		return DeltaCommand{}, fmt.Errorf("unknown command tag: %d", tag)
+	}
+}
+
+// ApplyDeltaStream applies a delta read from delta directly to out, decoding and applying one command at a time
+// instead of materializing the full command slice or the files on disk. Because out is a plain io.Writer rather
+// than a WriteSeeker, commands must cover the output contiguously from position zero - exactly what GenerateDelta
+// produces.
+func ApplyDeltaStream(original io.ReadSeeker, delta io.Reader, out io.Writer) error {
+	br := bufio.NewReader(delta)
+	if err := readDeltaHeader(br); err != nil {
+		return err
+	}
+
+	var written int64
+	for {
+		cmd, err := decodeCommand(br)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if cmd.Position != written {
+			return fmt.Errorf("non-contiguous delta: command at position %d, stream is at %d", cmd.Position, written)
+		}
+
+		switch cmd.Command {
+		case "copy":
+			if _, err := original.Seek(cmd.Offset, io.SeekStart); err != nil {
+				return err
+			}
+			n, err := io.CopyN(out, original, cmd.Length)
+			written += n
+			if err != nil {
+				return err
+			}
+		case "insert":
+			n, err := out.Write(cmd.Data)
+			written += int64(n)
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown command: %s", cmd.Command)
+		}
+	}
+}
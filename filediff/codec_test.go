@@ -0,0 +1,71 @@
+package filediff
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestEncodeDecodeDeltaRoundTrip verifies that decoding an encoded delta reproduces the original commands.
+func TestEncodeDecodeDeltaRoundTrip(t *testing.T) {
+	cmds := []DeltaCommand{
+		{Command: "copy", Position: 0, Offset: 128, Length: 64, Hash: sha256.Sum256([]byte("block one"))},
+		{Command: "insert", Position: 64, Data: []byte("brand new bytes")},
+		{Command: "copy", Position: 80, Offset: 0, Length: 32, Hash: sha256.Sum256([]byte("block two"))},
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeDelta(&buf, cmds); err != nil {
+		t.Fatalf("EncodeDelta returned error: %v", err)
+	}
+
+	decoded, err := DecodeDelta(&buf)
+	if err != nil {
+		t.Fatalf("DecodeDelta returned error: %v", err)
+	}
+
+	if len(decoded) != len(cmds) {
+		t.Fatalf("expected %d commands, got %d", len(cmds), len(decoded))
+	}
+	for i, cmd := range cmds {
+		if !reflect.DeepEqual(decoded[i], cmd) {
+			t.Errorf("command %d: expected %+v, got %+v", i, cmd, decoded[i])
+		}
+	}
+}
+
+// TestDecodeDeltaRejectsBadMagic verifies that DecodeDelta refuses input that isn't a delta stream.
+func TestDecodeDeltaRejectsBadMagic(t *testing.T) {
+	_, err := DecodeDelta(strings.NewReader("not a delta"))
+	if err == nil {
+		t.Errorf("expected an error for a stream with an invalid magic header, got none")
+	}
+}
+
+// TestApplyDeltaStream verifies that a delta applied directly from its encoded form produces the expected output
+// bytes.
+func TestApplyDeltaStream(t *testing.T) {
+	original := []byte("The quick brown fox jumps over the lazy dog.")
+	cmds := []DeltaCommand{
+		{Command: "copy", Position: 0, Offset: 4, Length: 15}, // "quick brown fox"
+		{Command: "insert", Position: 15, Data: []byte(" leaps")},
+		{Command: "copy", Position: 21, Offset: 19, Length: 25}, // " jumps over the lazy dog."
+	}
+
+	var encoded bytes.Buffer
+	if err := EncodeDelta(&encoded, cmds); err != nil {
+		t.Fatalf("EncodeDelta returned error: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := ApplyDeltaStream(bytes.NewReader(original), &encoded, &out); err != nil {
+		t.Fatalf("ApplyDeltaStream returned error: %v", err)
+	}
+
+	expected := "quick brown fox leaps jumps over the lazy dog."
+	if out.String() != expected {
+		t.Errorf("expected %q, got %q", expected, out.String())
+	}
+}
@@ -1,8 +1,8 @@
 package filediff
 
 import (
-	"bufio"
 	"bytes"
+	"crypto/sha256"
 	"eigertest/rollinghash"
 	"fmt"
 	"io"
@@ -12,99 +12,113 @@ import (
 )
 
 const (
-	blockSize   = 1024     // Define the size of the block to read from files, used for hashing and diff operations.
+	// defaultWeakHashThresholdPct is the WeakHashThresholdPct used by DefaultOptions.
+	defaultWeakHashThresholdPct = 90
+
+	// sectionSize is the amount of updated content each worker in matchAll is responsible for.
 	sectionSize = 10485760 // 10MB sections
+
+	// sectionOverlap is how far past its section a worker is allowed to keep reading. It must be at least
+	// maxChunkSize so that a chunk starting just before a section boundary still gets to run to its natural
+	// content-defined cut point instead of being forced to end exactly at the boundary.
+	sectionOverlap = maxChunkSize
 )
 
+// Options controls how GenerateDelta compares two files.
+type Options struct {
+	// WeakHashThresholdPct is the maximum estimated percentage, in [0, 100],
+	// of the updated file's blocks that may be missing from the original
+	// before GenerateDelta gives up on block matching and emits a single
+	// insert for the whole updated file. Rolling a hash across content that
+	// is already known to have mostly changed doesn't find useful matches;
+	// it just burns CPU. Zero disables the check.
+	WeakHashThresholdPct float64
+}
+
+// DefaultOptions returns the Options GenerateDelta uses when none are supplied.
+func DefaultOptions() Options {
+	return Options{WeakHashThresholdPct: defaultWeakHashThresholdPct}
+}
+
+// BlockMeta identifies one content-defined block of the original file: where it lives and its strong hash, used to
+// confirm a weak-hash match before trusting it.
+type BlockMeta struct {
+	Offset     int64
+	Length     int64
+	StrongHash [32]byte
+}
+
 // DeltaCommand defines a structure for delta commands indicating how to transform the original file into the updated version.
+// Copy commands identify the source block by its byte range in the original file (Offset, Length) rather than a fixed-size
+// block index, since blocks produced by the content-defined chunker vary in size.
 type DeltaCommand struct {
-	Command    string
-	Position   int
-	BlockIndex int
-	Data       []byte
+	Command  string
+	Position int64    // destination offset in the output file
+	Offset   int64    // source offset in the original file (copy only)
+	Length   int64    // number of bytes to copy from the original file (copy only)
+	Hash     [32]byte // strong hash of the block, used to look it up in the original file (copy only)
+	Data     []byte   // literal bytes to write (insert only)
 }
 
-// hashFileBlocks computes and returns a map of hash values to their corresponding block indices in the specified file.
-// This function facilitates identifying unique blocks and their positions for generating deltas.
-func hashFileBlocks(filePath string) (map[int][]int, error) {
-	file, err := os.Open(filePath)
+// HashBlocks splits original into content-defined chunks and indexes them by their weak (rolling) hash, so that
+// GenerateDeltaIO can cheaply test candidate blocks of the updated content for a match before paying for a strong
+// hash comparison. Multiple blocks can share a weak hash, so each bucket carries the strong hash needed to confirm
+// (or reject) a candidate. original only needs to support random reads, which lets callers plug in an *os.File, an
+// in-memory buffer, or a store such as afero.Fs or an S3 object.
+func HashBlocks(original io.ReaderAt, size int64) (map[uint32][]BlockMeta, error) {
+	chunks, err := splitChunks(io.NewSectionReader(original, 0, size))
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
-
-	hashes := make(map[int][]int)
-	reader := bufio.NewReader(file)
-	buffer := make([]byte, blockSize)
-	index := 0
-
-	for {
-		bytesRead, err := reader.Read(buffer)
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return nil, err
-		}
-		if bytesRead == 0 {
-			break
-		}
-
-		rh := rollinghash.New(bytesRead)
-		rh.HashData(buffer[:bytesRead])
 
-		hash := rh.GetHash()
-		if _, exists := hashes[hash]; !exists {
-			hashes[hash] = make([]int, 0)
-		}
-		hashes[hash] = append(hashes[hash], index)
-
-		index++
+	hashes := make(map[uint32][]BlockMeta, len(chunks))
+	for _, c := range chunks {
+		hashes[c.WeakHash] = append(hashes[c.WeakHash], BlockMeta{
+			Offset:     c.Offset,
+			Length:     c.Length,
+			StrongHash: c.Hash,
+		})
 	}
 
 	return hashes, nil
 }
 
-// ApplyDelta applies a series of delta commands to transform the original file into its updated version, resulting in a new output file.
-// It manages file seeking and writes based on the delta instructions, handling both copy and insert operations.
-func ApplyDelta(originalFilePath string, deltaCommands []DeltaCommand, outputFilePath string) error {
-	originalFile, err := os.Open(originalFilePath)
+// hashFileBlocks is a path-based convenience wrapper around HashBlocks.
+func hashFileBlocks(filePath string) (map[uint32][]BlockMeta, error) {
+	file, err := os.Open(filePath)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer originalFile.Close()
+	defer file.Close()
 
-	outputFile, err := os.OpenFile(outputFilePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	info, err := file.Stat()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer outputFile.Close()
 
+	return HashBlocks(file, info.Size())
+}
+
+// ApplyDeltaTo applies deltaCommands against original, writing the result to out. original only needs random reads
+// (io.ReaderAt); out needs to support seeking (io.WriteSeeker) since commands are applied in the order they appear
+// in deltaCommands, which is not necessarily destination-position order.
+func ApplyDeltaTo(original io.ReaderAt, deltaCommands []DeltaCommand, out io.WriteSeeker) error {
 	for _, command := range deltaCommands {
 		switch command.Command {
 		case "copy":
-			offset := int64(command.BlockIndex * blockSize)
-			_, err = originalFile.Seek(offset, io.SeekStart)
-			if err != nil {
-				return err
-			}
-
-			_, err := outputFile.Seek(int64(command.Position), io.SeekStart)
-			if err != nil {
+			if _, err := out.Seek(command.Position, io.SeekStart); err != nil {
 				return err
 			}
-			_, err = io.CopyN(outputFile, originalFile, blockSize)
-			if err != nil && err != io.EOF {
+			src := io.NewSectionReader(original, command.Offset, command.Length)
+			if _, err := io.Copy(out, src); err != nil {
 				return err
 			}
 
 		case "insert":
-			_, err = outputFile.Seek(int64(command.Position), io.SeekStart)
-			if err != nil {
+			if _, err := out.Seek(command.Position, io.SeekStart); err != nil {
 				return err
 			}
-			_, err = outputFile.Write(command.Data)
-			if err != nil {
+			if _, err := out.Write(command.Data); err != nil {
 				return err
 			}
 		default:
@@ -115,13 +129,40 @@ func ApplyDelta(originalFilePath string, deltaCommands []DeltaCommand, outputFil
 	return nil
 }
 
-// GenerateDelta analyzes the differences between an original and an updated file,
-// producing a series of delta commands that describe how to transform the original file into the updated version.
-// This function leverages rolling hashing to efficiently identify matching blocks and generate appropriate commands.
+// ApplyDelta is a path-based convenience wrapper around ApplyDeltaTo: it applies deltaCommands to
+// originalFilePath and writes the result to outputFilePath, creating or truncating it as needed.
+func ApplyDelta(originalFilePath string, deltaCommands []DeltaCommand, outputFilePath string) error {
+	originalFile, err := os.Open(originalFilePath)
+	if err != nil {
+		return err
+	}
+	defer originalFile.Close()
+
+	outputFile, err := os.OpenFile(outputFilePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+
+	return ApplyDeltaTo(originalFile, deltaCommands, outputFile)
+}
+
+// GenerateDelta is a path-based convenience wrapper around GenerateDeltaIO, using DefaultOptions.
 func GenerateDelta(originalFilePath, updatedFilePath string) ([]DeltaCommand, error) {
-	originalHashes, err := hashFileBlocks(originalFilePath)
+	return GenerateDeltaWithOptions(originalFilePath, updatedFilePath, DefaultOptions())
+}
+
+// GenerateDeltaWithOptions is a path-based convenience wrapper around GenerateDeltaIO.
+func GenerateDeltaWithOptions(originalFilePath, updatedFilePath string, opts Options) ([]DeltaCommand, error) {
+	originalFile, err := os.Open(originalFilePath)
+	if err != nil {
+		fmt.Println("Error opening original file:", err)
+		return nil, err
+	}
+	defer originalFile.Close()
+
+	originalInfo, err := originalFile.Stat()
 	if err != nil {
-		fmt.Println("Error hashing original file blocks:", err)
 		return nil, err
 	}
 
@@ -132,140 +173,276 @@ func GenerateDelta(originalFilePath, updatedFilePath string) ([]DeltaCommand, er
 	}
 	defer updatedFile.Close()
 
-	fileInfo, err := updatedFile.Stat()
+	return GenerateDeltaIO(originalFile, originalInfo.Size(), updatedFile, opts)
+}
+
+// GenerateDeltaIO analyzes the differences between original and updated, producing a series of delta commands that
+// describe how to transform original into updated. original must support random reads (io.ReaderAt) since matching
+// blocks can be located anywhere in it; updated is only read once, front to back, as a plain io.Reader.
+//
+// This leverages content-defined chunking and a two-tier weak+strong hash match to efficiently identify matching
+// blocks and generate appropriate commands.
+func GenerateDeltaIO(original io.ReaderAt, originalSize int64, updated io.Reader, opts Options) ([]DeltaCommand, error) {
+	originalHashes, err := HashBlocks(original, originalSize)
 	if err != nil {
+		fmt.Println("Error hashing original file blocks:", err)
 		return nil, err
 	}
-	fileSize := fileInfo.Size()
-	numSections := int(fileSize) / sectionSize
 
-	var wg sync.WaitGroup
-	deltaChan := make(chan []DeltaCommand, numSections)
+	return deltaFromHashes(originalHashes, updated, opts)
+}
+
+// deltaFromHashes is the shared second half of GenerateDeltaIO and Delta: given a weak-hash index of the original
+// file's blocks - whether built locally by HashBlocks or received over the wire as a Signature - it buffers updated,
+// decides whether block matching is worth attempting, and produces the resulting commands.
+func deltaFromHashes(originalHashes map[uint32][]BlockMeta, updated io.Reader, opts Options) ([]DeltaCommand, error) {
+	// updated is only guaranteed to support a single sequential read, but both the threshold estimate below and
+	// the match pass need to scan it, so it is buffered once up front.
+	updatedData, err := io.ReadAll(updated)
+	if err != nil {
+		fmt.Println("Error reading updated content:", err)
+		return nil, err
+	}
 
-	for i := 0; i <= numSections; i++ {
+	if opts.WeakHashThresholdPct > 0 {
+		changedPct, err := estimateChangedPct(originalHashes, bytes.NewReader(updatedData))
+		if err != nil {
+			fmt.Println("Error estimating changed blocks:", err)
+			return nil, err
+		}
+		if changedPct > opts.WeakHashThresholdPct {
+			return insertAll(updatedData), nil
+		}
+	}
+
+	return matchAll(originalHashes, updatedData)
+}
+
+// estimateChangedPct returns a rough estimate, in [0, 100], of how much of updated is not already present in
+// originalHashes. GenerateDeltaIO uses this to decide whether block matching is worth attempting at all.
+func estimateChangedPct(originalHashes map[uint32][]BlockMeta, updated io.Reader) (float64, error) {
+	chunks, err := splitChunks(updated)
+	if err != nil {
+		return 0, err
+	}
+	if len(chunks) == 0 {
+		return 0, nil
+	}
+
+	matched := 0
+	for _, c := range chunks {
+		if blockMatches(originalHashes, c.WeakHash, c.Hash) {
+			matched++
+		}
+	}
+
+	changed := len(chunks) - matched
+	return float64(changed) / float64(len(chunks)) * 100, nil
+}
+
+// blockMatches reports whether a block with the given weak and strong hash is present in originalHashes. The weak
+// hash narrows the search to a small bucket; the strong hash confirms the match so a weak-hash collision can never
+// produce a false copy.
+func blockMatches(originalHashes map[uint32][]BlockMeta, weakHash uint32, strongHash [32]byte) bool {
+	for _, m := range originalHashes[weakHash] {
+		if m.StrongHash == strongHash {
+			return true
+		}
+	}
+	return false
+}
+
+// insertAll builds a delta consisting of a single insert covering all of data. It is used when GenerateDeltaIO
+// determines that block matching is unlikely to pay off.
+func insertAll(data []byte) []DeltaCommand {
+	if len(data) == 0 {
+		return nil
+	}
+	return []DeltaCommand{{Command: "insert", Position: 0, Data: data}}
+}
+
+// matchAll finds matching blocks across the whole of data. For inputs larger than a single section it splits the
+// work across concurrent workers, one per sectionSize-byte range, each allowed to read sectionOverlap bytes past
+// its own section so that a chunk starting near a section boundary still runs to its natural content-defined
+// cut point rather than being forced to end exactly at the boundary. Because of that overlap, adjacent workers can
+// both emit a command covering some of the same destination bytes; those are reconciled afterwards by
+// dedupeOverlaps, and any resulting run of adjacent copies is collapsed by mergeContiguousCopies.
+func matchAll(originalHashes map[uint32][]BlockMeta, data []byte) ([]DeltaCommand, error) {
+	if len(data) <= sectionSize {
+		delta, err := matchSection(originalHashes, data, 0, len(data), len(data))
+		if err != nil {
+			return nil, err
+		}
+		return mergeContiguousCopies(dedupeOverlaps(delta)), nil
+	}
+
+	numSections := (len(data) + sectionSize - 1) / sectionSize
+	results := make([][]DeltaCommand, numSections)
+	errs := make([]error, numSections)
+
+	var wg sync.WaitGroup
+	for i := 0; i < numSections; i++ {
 		start := i * sectionSize
 		end := start + sectionSize
-		if end > int(fileSize) {
-			end = int(fileSize)
+		if end > len(data) {
+			end = len(data)
+		}
+		readEnd := end + sectionOverlap
+		if readEnd > len(data) {
+			readEnd = len(data)
 		}
 
 		wg.Add(1)
-		go func(start, end int) {
+		go func(i, start, end, readEnd int) {
 			defer wg.Done()
-			sectionDelta, _ := processSection(originalHashes, updatedFilePath, start, end)
-			deltaChan <- sectionDelta
-		}(start, end)
+			results[i], errs[i] = matchSection(originalHashes, data, start, end, readEnd)
+		}(i, start, end, readEnd)
 	}
+	wg.Wait()
 
-	go func() {
-		wg.Wait()
-		close(deltaChan)
-	}()
-
-	var deltas []DeltaCommand
-	for sectionDelta := range deltaChan {
-		deltas = append(deltas, sectionDelta...)
+	var delta []DeltaCommand
+	for i, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+		delta = append(delta, results[i]...)
 	}
 
-	// Sort the deltas slice by Position to ensure they are in order
-	sort.Slice(deltas, func(i, j int) bool {
-		return deltas[i].Position < deltas[j].Position
+	sort.Slice(delta, func(i, j int) bool {
+		return delta[i].Position < delta[j].Position
 	})
 
-	return deltas, nil
+	return mergeContiguousCopies(dedupeOverlaps(delta)), nil
 }
 
-func processSection(originalHashes map[int][]int, filePath string, start, end int) ([]DeltaCommand, error) {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return nil, fmt.Errorf("error opening file: %w", err)
-	}
-	defer file.Close()
-
-	// Seek to the start position of this section
-	_, err = file.Seek(int64(start), io.SeekStart)
-	if err != nil {
-		return nil, fmt.Errorf("error seeking file: %w", err)
-	}
-
-	reader := bufio.NewReader(file)
+// matchSection splits data[start:readEnd] into content-defined chunks but only starts new chunks while
+// chunkStart < end - once a chunk cuts at or past end, the rest of [end, readEnd) is left for the next section's
+// worker to (re-)process. For each chunk it checks the weak-hash bucket in originalHashes and only trusts a match
+// once the block's strong hash also matches, emitting a copy command; otherwise it emits an insert.
+func matchSection(originalHashes map[uint32][]BlockMeta, data []byte, start, end, readEnd int) ([]DeltaCommand, error) {
+	rh := rollinghash.NewBuzhash(chunkWindow)
 	var delta []DeltaCommand
-	var currentWindow bytes.Buffer
+	var window bytes.Buffer
 	position := start
+	chunkStart := start
 
-	// Adjust the read loop to stop when reaching the end of the section
-	for position < end {
-		b, err := reader.ReadByte()
-		if err != nil {
-			if err == io.EOF {
-				break // End of file is expected, depending on section end
+	emit := func(weakHash uint32) {
+		if window.Len() == 0 {
+			return
+		}
+		if candidates := originalHashes[weakHash]; len(candidates) > 0 {
+			sum := sha256.Sum256(window.Bytes())
+			for _, m := range candidates {
+				if m.StrongHash == sum {
+					delta = append(delta, DeltaCommand{
+						Command:  "copy",
+						Position: int64(chunkStart),
+						Offset:   m.Offset,
+						Length:   m.Length,
+						Hash:     sum,
+					})
+					window.Reset()
+					rh.Reset()
+					chunkStart = position
+					return
+				}
 			}
-			return nil, fmt.Errorf("error reading byte from file: %w", err)
 		}
+		delta = append(delta, DeltaCommand{
+			Command:  "insert",
+			Position: int64(chunkStart),
+			Data:     append([]byte(nil), window.Bytes()...),
+		})
+		window.Reset()
+		rh.Reset()
+		chunkStart = position
+	}
+
+	for position < readEnd && chunkStart < end {
+		b := data[position]
+		window.WriteByte(b)
+		hash := rh.Roll(b)
+		position++
 
-		currentWindow.WriteByte(b)
-		if currentWindow.Len() > blockSize {
-			_, _ = currentWindow.ReadByte() // Keep the window size constant
+		if atChunkBoundary(window.Len(), hash) || position == readEnd {
+			emit(hash)
 		}
+	}
 
-		// Ensure we only process full blocks or the last block in the section
-		if currentWindow.Len() == blockSize || position == end-1 {
-			rh := rollinghash.New(min(blockSize, currentWindow.Len()))
-			tempWindow := currentWindow.Bytes()
-			for _, b := range tempWindow {
-				rh.AddByte(b)
-			}
+	return delta, nil
+}
 
-			hash := rh.GetHash()
-			if indexes, exists := originalHashes[hash]; exists && len(indexes) > 0 {
-				// Generate copy command if hash matches
-				deltaPosition := max(position+1-blockSize, start)
-				delta = append(delta, DeltaCommand{
-					Command:    "copy",
-					BlockIndex: indexes[0], // Assuming first occurrence is the match
-					Position:   deltaPosition,
-				})
-				currentWindow.Reset() // Reset window after matching
-			} else if position == end-1 {
-				// Insert the remaining bytes at the end of the section
-				deltaPosition := position - currentWindow.Len() + 1
-				delta = append(delta, DeltaCommand{
-					Command:  "insert",
-					Position: deltaPosition,
-					Data:     tempWindow,
-				})
-				currentWindow.Reset()
+// dedupeOverlaps walks delta, sorted by Position, and trims any command that overlaps the destination range
+// already covered by the previous one. A command fully covered by its predecessor is dropped entirely.
+func dedupeOverlaps(delta []DeltaCommand) []DeltaCommand {
+	if len(delta) == 0 {
+		return delta
+	}
+
+	result := make([]DeltaCommand, 0, len(delta))
+	result = append(result, delta[0])
+	covered := delta[0].Position + commandLength(delta[0])
+
+	for _, cmd := range delta[1:] {
+		if cmd.Position < covered {
+			overlap := covered - cmd.Position
+			cmd = trimFront(cmd, overlap)
+			if commandLength(cmd) <= 0 {
+				continue
 			}
 		}
+		result = append(result, cmd)
+		covered = cmd.Position + commandLength(cmd)
+	}
 
-		position++
+	return result
+}
+
+// mergeContiguousCopies collapses runs of adjacent copy commands whose source and destination ranges are both
+// contiguous into a single multi-block copy, keeping the delta compact after content-defined chunking.
+func mergeContiguousCopies(delta []DeltaCommand) []DeltaCommand {
+	if len(delta) == 0 {
+		return delta
 	}
 
-	// Handle any remaining bytes in the window as inserts, if not already done
-	if currentWindow.Len() > 0 {
-		fmt.Printf("Insert command at section end - Position: %d, Data Length: %d\n", position-currentWindow.Len(), currentWindow.Len())
-		delta = append(delta, DeltaCommand{
-			Command:  "insert",
-			Position: position - currentWindow.Len(),
-			Data:     currentWindow.Bytes(),
-		})
+	merged := make([]DeltaCommand, 0, len(delta))
+	merged = append(merged, delta[0])
+
+	for _, cmd := range delta[1:] {
+		last := &merged[len(merged)-1]
+		if cmd.Command == "copy" && last.Command == "copy" &&
+			cmd.Position == last.Position+last.Length &&
+			cmd.Offset == last.Offset+last.Length {
+			last.Length += cmd.Length
+			last.Hash = [32]byte{} // no longer identifies a single block
+			continue
+		}
+		merged = append(merged, cmd)
 	}
 
-	return delta, nil
+	return merged
 }
 
-// Helper function to ensure we don't exceed buffer bounds
-func min(a, b int) int {
-	if a < b {
-		return a
+// commandLength returns the number of destination bytes cmd covers.
+func commandLength(cmd DeltaCommand) int64 {
+	if cmd.Command == "copy" {
+		return cmd.Length
 	}
-	return b
+	return int64(len(cmd.Data))
 }
 
-// Helper function to ensure we correctly position delta commands
-func max(a, b int) int {
-	if a > b {
-		return a
+// trimFront removes the first n destination bytes from cmd, adjusting its source range (copy) or literal data
+// (insert) to match.
+func trimFront(cmd DeltaCommand, n int64) DeltaCommand {
+	if n > commandLength(cmd) {
+		n = commandLength(cmd)
+	}
+	cmd.Position += n
+	if cmd.Command == "copy" {
+		cmd.Offset += n
+		cmd.Length -= n
+	} else {
+		cmd.Data = cmd.Data[n:]
 	}
-	return b
+	return cmd
 }
@@ -2,10 +2,63 @@ package filediff
 
 import (
 	"bytes"
+	"math/rand"
 	"os"
 	"testing"
 )
 
+// TestGenerateAndApplyDeltaIO exercises GenerateDeltaIO and ApplyDeltaTo entirely against in-memory buffers, with
+// no filesystem paths involved, to verify the io.ReaderAt/io.WriteSeeker based API works standalone. The fixture is
+// well over maxChunkSize with only a small localized edit, so the comparison stays under WeakHashThresholdPct and
+// actually exercises block matching instead of degenerating into a single whole-file insert.
+func TestGenerateAndApplyDeltaIO(t *testing.T) {
+	original := make([]byte, avgChunkSize*20)
+	rand.New(rand.NewSource(4)).Read(original)
+
+	updated := append([]byte{}, original[:avgChunkSize]...)
+	updated = append(updated, []byte(" a small localized edit ")...)
+	updated = append(updated, original[avgChunkSize:]...)
+
+	delta, err := GenerateDeltaIO(bytes.NewReader(original), int64(len(original)), bytes.NewReader(updated), DefaultOptions())
+	if err != nil {
+		t.Fatalf("GenerateDeltaIO returned error: %v", err)
+	}
+
+	hasCopy := false
+	for _, cmd := range delta {
+		if cmd.Command == "copy" {
+			hasCopy = true
+			break
+		}
+	}
+	if !hasCopy {
+		t.Fatalf("expected at least one copy command, got none: %+v", delta)
+	}
+
+	var out bytes.Buffer
+	if err := ApplyDeltaTo(bytes.NewReader(original), delta, &nopWriteSeeker{&out}); err != nil {
+		t.Fatalf("ApplyDeltaTo returned error: %v", err)
+	}
+
+	if !bytes.Equal(out.Bytes(), updated) {
+		t.Errorf("round trip mismatch: expected %d bytes, got %d", len(updated), out.Len())
+	}
+}
+
+// nopWriteSeeker adapts a bytes.Buffer, which only grows, into an io.WriteSeeker suitable for tests that apply
+// commands in position order.
+type nopWriteSeeker struct {
+	buf *bytes.Buffer
+}
+
+func (w *nopWriteSeeker) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *nopWriteSeeker) Seek(offset int64, whence int) (int64, error) {
+	return offset, nil
+}
+
 // TestHashFileBlocks tests the hashFileBlocks function for a basic case.
 func TestHashFileBlocks(t *testing.T) {
 	// Setup: create a temporary file with known content
@@ -83,12 +136,13 @@ func TestApplyDeltaEmptyCommands(t *testing.T) {
 // TestApplyDeltaInvalidCommands verifies that ApplyDelta handles invalid delta commands gracefully.
 func TestApplyDeltaInvalidCommands(t *testing.T) {
 	originalContent := []byte("Some original content.")
-	// A copy command with an invalid block index.
+	// A copy command with a negative source offset.
 	deltaCommands := []DeltaCommand{
 		{
-			Command:    "copy",
-			Position:   0,
-			BlockIndex: -1, // Invalid block index
+			Command:  "copy",
+			Position: 0,
+			Offset:   -1, // Invalid offset
+			Length:   1,
 		},
 	}
 
@@ -116,3 +170,162 @@ func TestApplyDeltaInvalidCommands(t *testing.T) {
 		t.Errorf("Expected an error for invalid delta commands, but got none")
 	}
 }
+
+// TestGenerateDeltaWithOptionsSkipsRollingWhenMostlyChanged verifies that once the estimated changed percentage
+// exceeds WeakHashThresholdPct, GenerateDeltaWithOptions gives up on block matching and emits a single insert.
+func TestGenerateDeltaWithOptionsSkipsRollingWhenMostlyChanged(t *testing.T) {
+	originalFile, err := os.CreateTemp("", "original")
+	if err != nil {
+		t.Fatalf("Failed to create temporary original file: %v", err)
+	}
+	defer os.Remove(originalFile.Name())
+	if _, err := originalFile.Write(bytes.Repeat([]byte("original content "), 100)); err != nil {
+		t.Fatalf("Failed to write original file: %v", err)
+	}
+	originalFile.Close()
+
+	updatedFile, err := os.CreateTemp("", "updated")
+	if err != nil {
+		t.Fatalf("Failed to create temporary updated file: %v", err)
+	}
+	defer os.Remove(updatedFile.Name())
+	updatedContent := bytes.Repeat([]byte("entirely rewritten content "), 100)
+	if _, err := updatedFile.Write(updatedContent); err != nil {
+		t.Fatalf("Failed to write updated file: %v", err)
+	}
+	updatedFile.Close()
+
+	delta, err := GenerateDeltaWithOptions(originalFile.Name(), updatedFile.Name(), Options{WeakHashThresholdPct: 1})
+	if err != nil {
+		t.Fatalf("GenerateDeltaWithOptions returned error: %v", err)
+	}
+
+	if len(delta) != 1 || delta[0].Command != "insert" || !bytes.Equal(delta[0].Data, updatedContent) {
+		t.Errorf("expected a single insert covering the whole file, got %+v", delta)
+	}
+}
+
+// TestMatchSectionFindsMatchStraddlingBoundary verifies that a block whose natural chunk boundary falls past a
+// section's end is still matched in full, rather than being cut short exactly at the boundary.
+func TestMatchSectionFindsMatchStraddlingBoundary(t *testing.T) {
+	original := bytes.Repeat([]byte("0123456789"), avgChunkSize/5) // periodic content with no cut point: one chunk ending at EOF
+	originalHashes, err := HashBlocks(bytes.NewReader(original), int64(len(original)))
+	if err != nil {
+		t.Fatalf("HashBlocks returned error: %v", err)
+	}
+
+	// Force an artificial section boundary in the middle of the data, far from any natural chunk cut.
+	end := len(original) / 2
+	readEnd := len(original)
+
+	delta, err := matchSection(originalHashes, original, 0, end, readEnd)
+	if err != nil {
+		t.Fatalf("matchSection returned error: %v", err)
+	}
+
+	var coveredCopy int64
+	for _, cmd := range delta {
+		if cmd.Command == "copy" {
+			coveredCopy += cmd.Length
+		}
+	}
+	if coveredCopy == 0 {
+		t.Errorf("expected at least one copy command, got none: %+v", delta)
+	}
+	// The section should still find matches beyond `end`, since it is allowed to read up to readEnd.
+	if len(delta) == 0 || delta[len(delta)-1].Position+commandLength(delta[len(delta)-1]) <= int64(end) {
+		t.Errorf("expected the last chunk to extend past the section boundary at %d, got %+v", end, delta)
+	}
+}
+
+// TestDedupeOverlapsTrimsOverlappingCommand verifies that a command whose destination range overlaps the
+// preceding one is trimmed rather than dropped, so the merged delta still covers every byte exactly once.
+func TestDedupeOverlapsTrimsOverlappingCommand(t *testing.T) {
+	insertData := []byte("0123456789ABCDE") // 15 bytes
+	delta := []DeltaCommand{
+		{Command: "copy", Position: 0, Offset: 100, Length: 30},
+		{Command: "insert", Position: 20, Data: insertData}, // overlaps the first command by 10 bytes
+	}
+
+	result := dedupeOverlaps(delta)
+
+	if len(result) != 2 {
+		t.Fatalf("expected 2 commands after dedupe, got %d: %+v", len(result), result)
+	}
+	if result[1].Position != 30 || !bytes.Equal(result[1].Data, insertData[10:]) {
+		t.Errorf("expected the second command to be trimmed to start at 30 with 5 trailing bytes, got %+v", result[1])
+	}
+}
+
+// TestMergeContiguousCopiesCombinesAdjacentBlocks verifies that adjacent copy commands whose source and
+// destination ranges are both contiguous collapse into a single multi-block copy.
+func TestMergeContiguousCopiesCombinesAdjacentBlocks(t *testing.T) {
+	delta := []DeltaCommand{
+		{Command: "copy", Position: 0, Offset: 100, Length: 10},
+		{Command: "copy", Position: 10, Offset: 110, Length: 20},
+		{Command: "insert", Position: 30, Data: []byte("gap")},
+		{Command: "copy", Position: 33, Offset: 500, Length: 5},
+	}
+
+	merged := mergeContiguousCopies(delta)
+
+	if len(merged) != 3 {
+		t.Fatalf("expected 3 commands after merging, got %d: %+v", len(merged), merged)
+	}
+	if merged[0].Command != "copy" || merged[0].Offset != 100 || merged[0].Length != 30 {
+		t.Errorf("expected the first two copies to merge into one 30-byte copy, got %+v", merged[0])
+	}
+}
+
+// TestMatchAllAcrossSectionBoundariesRoundTrip exercises matchAll's concurrent, multi-section path - the actual
+// parallelization chunk0-5 added - by diffing a buffer well over sectionSize against a copy with one small localized
+// edit, so the data crosses two section boundaries. It checks both that the result round-trips correctly (the
+// overlap reads at each boundary found the real chunk boundaries) and that most of the unchanged content collapsed
+// into large, contiguous copy commands rather than leaving the small per-worker overlap windows as separate,
+// overlapping, unmerged copies.
+func TestMatchAllAcrossSectionBoundariesRoundTrip(t *testing.T) {
+	size := 2*sectionSize + sectionSize/2
+	original := make([]byte, size)
+	rand.New(rand.NewSource(5)).Read(original)
+
+	editAt := sectionSize / 2
+	updated := append([]byte{}, original[:editAt]...)
+	updated = append(updated, []byte("a small localized edit")...)
+	updated = append(updated, original[editAt:]...)
+
+	originalHashes, err := HashBlocks(bytes.NewReader(original), int64(len(original)))
+	if err != nil {
+		t.Fatalf("HashBlocks returned error: %v", err)
+	}
+
+	delta, err := matchAll(originalHashes, updated)
+	if err != nil {
+		t.Fatalf("matchAll returned error: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := ApplyDeltaTo(bytes.NewReader(original), delta, &nopWriteSeeker{&out}); err != nil {
+		t.Fatalf("ApplyDeltaTo returned error: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), updated) {
+		t.Fatalf("round trip mismatch across section boundaries: expected %d bytes, got %d", len(updated), out.Len())
+	}
+
+	var copies, copiedBytes int64
+	for _, cmd := range delta {
+		if cmd.Command == "copy" {
+			copies++
+			copiedBytes += cmd.Length
+		}
+	}
+	if copies == 0 {
+		t.Fatalf("expected at least one copy command, got none: %d commands total", len(delta))
+	}
+	if copiedBytes < int64(len(original))/2 {
+		t.Errorf("expected most of the original content to be copied, only %d of %d bytes were", copiedBytes, len(original))
+	}
+	if avgCopyLength := copiedBytes / copies; avgCopyLength <= maxChunkSize {
+		t.Errorf("expected mergeContiguousCopies to collapse contiguous blocks into copies larger than a single "+
+			"chunk, average copy length was only %d bytes", avgCopyLength)
+	}
+}
@@ -0,0 +1,217 @@
+package filediff
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Wire format for a serialized signature, mirroring the delta format in codec.go:
+//
+//	magic (4 bytes)     "FSIG"
+//	version (1 byte)    sigFormatVersion
+//	block* until EOF:
+//	  weak hash (uvarint)
+//	  offset (varint)
+//	  length (varint)
+//	  strong hash (32 raw bytes)
+const (
+	sigMagic         = "FSIG"
+	sigFormatVersion = 1
+)
+
+// SignatureBlock is one content-defined block as recorded in a Signature: its byte range in the file the signature
+// describes, its weak (rolling) hash, and its strong hash.
+type SignatureBlock struct {
+	Offset     int64
+	Length     int64
+	WeakHash   uint32
+	StrongHash [32]byte
+}
+
+// Signature is a compact, serializable description of a file's content-defined blocks - everything a sender needs
+// to compute a delta against the file without holding a copy of it. This turns GenerateDelta's local two-file
+// comparison into the two-roundtrip sync protocol librsync and zsync use:
+//
+//	receiver: sig, _ := NewSignature(original)   // cheap, local to the receiver
+//	          sig.EncodeTo(conn)                 // ships the signature to the sender
+//	sender:   sig, _ := DecodeSignature(conn)
+//	          Delta(sig, updated, conn)          // ships the resulting delta back
+//	receiver: Patch(sig, original, conn, out)    // applies it against its own copy
+//
+// so only the signature and the delta ever cross the network - never a full copy of either file.
+type Signature struct {
+	// Size is the total length, in bytes, of the file the signature was built from.
+	Size int64
+
+	// Blocks holds every block of the file, in offset order.
+	Blocks []SignatureBlock
+
+	// hashes indexes Blocks by weak hash, the same shape HashBlocks produces, so Delta can feed it straight into
+	// matchAll.
+	hashes map[uint32][]BlockMeta
+}
+
+// NewSignature splits original into content-defined blocks and records their weak+strong hashes. Unlike HashBlocks,
+// it only needs a plain io.Reader - original is read once, front to back - since a signature describes a file the
+// caller already holds locally rather than one a matcher needs to seek around in.
+func NewSignature(original io.Reader) (*Signature, error) {
+	chunks, err := splitChunks(original)
+	if err != nil {
+		return nil, err
+	}
+
+	sig := &Signature{
+		Blocks: make([]SignatureBlock, 0, len(chunks)),
+		hashes: make(map[uint32][]BlockMeta, len(chunks)),
+	}
+	for _, c := range chunks {
+		sig.Blocks = append(sig.Blocks, SignatureBlock{Offset: c.Offset, Length: c.Length, WeakHash: c.WeakHash, StrongHash: c.Hash})
+		sig.hashes[c.WeakHash] = append(sig.hashes[c.WeakHash], BlockMeta{Offset: c.Offset, Length: c.Length, StrongHash: c.Hash})
+		sig.Size += c.Length
+	}
+
+	return sig, nil
+}
+
+// EncodeTo writes the signature to w using the wire format documented above, so it can be shipped over a network or
+// stored rather than only existing as an in-memory *Signature.
+func (s *Signature) EncodeTo(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	if _, err := bw.WriteString(sigMagic); err != nil {
+		return err
+	}
+	if err := bw.WriteByte(sigFormatVersion); err != nil {
+		return err
+	}
+
+	var varintBuf [binary.MaxVarintLen64]byte
+	for _, b := range s.Blocks {
+		n := binary.PutUvarint(varintBuf[:], uint64(b.WeakHash))
+		if _, err := bw.Write(varintBuf[:n]); err != nil {
+			return err
+		}
+		n = binary.PutVarint(varintBuf[:], b.Offset)
+		if _, err := bw.Write(varintBuf[:n]); err != nil {
+			return err
+		}
+		n = binary.PutVarint(varintBuf[:], b.Length)
+		if _, err := bw.Write(varintBuf[:n]); err != nil {
+			return err
+		}
+		if _, err := bw.Write(b.StrongHash[:]); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// DecodeSignature reads a signature previously written by (*Signature).EncodeTo.
+func DecodeSignature(r io.Reader) (*Signature, error) {
+	br := bufio.NewReader(r)
+
+	magic := make([]byte, len(sigMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return nil, fmt.Errorf("reading signature magic: %w", err)
+	}
+	if string(magic) != sigMagic {
+		return nil, fmt.Errorf("not a signature stream: bad magic %q", magic)
+	}
+
+	version, err := br.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("reading signature version: %w", err)
+	}
+	if version != sigFormatVersion {
+		return nil, fmt.Errorf("unsupported signature format version %d", version)
+	}
+
+	sig := &Signature{hashes: make(map[uint32][]BlockMeta)}
+	for {
+		weakHash, err := binary.ReadUvarint(br)
+		if err != nil {
+			if err == io.EOF {
+				return sig, nil
+			}
+			return nil, fmt.Errorf("reading weak hash: %w", err)
+		}
+		offset, err := binary.ReadVarint(br)
+		if err != nil {
+			return nil, fmt.Errorf("reading offset: %w", err)
+		}
+		length, err := binary.ReadVarint(br)
+		if err != nil {
+			return nil, fmt.Errorf("reading length: %w", err)
+		}
+		var strongHash [32]byte
+		if _, err := io.ReadFull(br, strongHash[:]); err != nil {
+			return nil, fmt.Errorf("reading strong hash: %w", err)
+		}
+
+		sig.Blocks = append(sig.Blocks, SignatureBlock{Offset: offset, Length: length, WeakHash: uint32(weakHash), StrongHash: strongHash})
+		sig.hashes[uint32(weakHash)] = append(sig.hashes[uint32(weakHash)], BlockMeta{Offset: offset, Length: length, StrongHash: strongHash})
+		sig.Size += length
+	}
+}
+
+// Delta computes a delta that transforms the file sig describes into updated, and writes it directly to w in the
+// wire format DecodeDelta/Patch understand. It is the sender's half of the two-roundtrip protocol: sig travelled
+// over the network from the receiver, so the sender never needs a copy of the original file at all, only updated
+// and the signature computed against it.
+func Delta(sig *Signature, updated io.Reader, w io.Writer) error {
+	cmds, err := deltaFromHashes(sig.hashes, updated, DefaultOptions())
+	if err != nil {
+		return err
+	}
+
+	return EncodeDelta(w, cmds)
+}
+
+// Patch applies a delta produced by Delta against original, writing the result to out. It is the receiver's half of
+// the two-roundtrip protocol: original only needs random reads (io.ReaderAt), and out is a plain io.Writer, so -
+// like ApplyDeltaStream - commands must cover the output contiguously starting at position zero, exactly what Delta
+// produces. sig is the signature the delta was computed against; Patch uses its Size to reject a copy command that
+// reaches past the file sig describes, which catches a delta being replayed against the wrong original.
+func Patch(sig *Signature, original io.ReaderAt, delta io.Reader, out io.Writer) error {
+	br := bufio.NewReader(delta)
+	if err := readDeltaHeader(br); err != nil {
+		return err
+	}
+
+	var written int64
+	for {
+		cmd, err := decodeCommand(br)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if cmd.Position != written {
+			return fmt.Errorf("non-contiguous delta: command at position %d, stream is at %d", cmd.Position, written)
+		}
+
+		switch cmd.Command {
+		case "copy":
+			if cmd.Offset < 0 || cmd.Offset+cmd.Length > sig.Size {
+				return fmt.Errorf("copy command references [%d, %d), past signature size %d", cmd.Offset, cmd.Offset+cmd.Length, sig.Size)
+			}
+			n, err := io.Copy(out, io.NewSectionReader(original, cmd.Offset, cmd.Length))
+			written += n
+			if err != nil {
+				return err
+			}
+		case "insert":
+			n, err := out.Write(cmd.Data)
+			written += int64(n)
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown command: %s", cmd.Command)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package filediff
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// TestSignatureDeltaPatchRoundTrip exercises the full two-roundtrip protocol - NewSignature, Delta, Patch - end to
+// end, with the signature and delta each passing through their wire format, mirroring how they would actually cross
+// a network. The fixture is well over maxChunkSize with only a small localized edit, so the comparison stays under
+// WeakHashThresholdPct and Delta actually has to emit copy commands instead of one whole-file insert.
+func TestSignatureDeltaPatchRoundTrip(t *testing.T) {
+	original := make([]byte, avgChunkSize*20)
+	rand.New(rand.NewSource(6)).Read(original)
+
+	updated := append([]byte{}, original[:avgChunkSize]...)
+	updated = append(updated, []byte(" a small localized edit ")...)
+	updated = append(updated, original[avgChunkSize:]...)
+
+	sig, err := NewSignature(bytes.NewReader(original))
+	if err != nil {
+		t.Fatalf("NewSignature returned error: %v", err)
+	}
+
+	var sigWire bytes.Buffer
+	if err := sig.EncodeTo(&sigWire); err != nil {
+		t.Fatalf("EncodeTo returned error: %v", err)
+	}
+	decodedSig, err := DecodeSignature(&sigWire)
+	if err != nil {
+		t.Fatalf("DecodeSignature returned error: %v", err)
+	}
+
+	var deltaWire bytes.Buffer
+	if err := Delta(decodedSig, bytes.NewReader(updated), &deltaWire); err != nil {
+		t.Fatalf("Delta returned error: %v", err)
+	}
+	deltaBytes := deltaWire.Bytes()
+
+	cmds, err := DecodeDelta(bytes.NewReader(deltaBytes))
+	if err != nil {
+		t.Fatalf("DecodeDelta returned error: %v", err)
+	}
+	hasCopy := false
+	for _, cmd := range cmds {
+		if cmd.Command == "copy" {
+			hasCopy = true
+			break
+		}
+	}
+	if !hasCopy {
+		t.Fatalf("expected at least one copy command, got none: %+v", cmds)
+	}
+
+	var out bytes.Buffer
+	if err := Patch(decodedSig, bytes.NewReader(original), bytes.NewReader(deltaBytes), &out); err != nil {
+		t.Fatalf("Patch returned error: %v", err)
+	}
+
+	if !bytes.Equal(out.Bytes(), updated) {
+		t.Errorf("round trip mismatch: expected %d bytes, got %d", len(updated), out.Len())
+	}
+}
+
+// TestPatchRejectsCopyPastSignatureSize verifies that Patch refuses a delta whose copy command reaches past the
+// file the signature describes, instead of silently reading garbage or panicking.
+func TestPatchRejectsCopyPastSignatureSize(t *testing.T) {
+	original := []byte("short original")
+	sig, err := NewSignature(bytes.NewReader(original))
+	if err != nil {
+		t.Fatalf("NewSignature returned error: %v", err)
+	}
+
+	var deltaWire bytes.Buffer
+	if err := EncodeDelta(&deltaWire, []DeltaCommand{
+		{Command: "copy", Position: 0, Offset: 0, Length: sig.Size + 100},
+	}); err != nil {
+		t.Fatalf("EncodeDelta returned error: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := Patch(sig, bytes.NewReader(original), &deltaWire, &out); err == nil {
+		t.Errorf("expected Patch to reject a copy command past the signature size, got none")
+	}
+}
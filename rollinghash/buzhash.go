@@ -0,0 +1,82 @@
+package rollinghash
+
+// buzTable holds one pseudo-random 32-bit constant per possible byte value.
+// It is generated deterministically with a fixed-seed splitmix64 sequence
+// so that chunk boundaries computed with Buzhash are stable across runs,
+// processes and platforms rather than depending on math/rand's global seed.
+var buzTable = newBuzTable()
+
+func newBuzTable() [256]uint32 {
+	var table [256]uint32
+	state := uint64(0x9e3779b97f4a7c15)
+	for i := range table {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		z ^= z >> 31
+		table[i] = uint32(z)
+	}
+	return table
+}
+
+// Buzhash is a cyclic-polynomial rolling hash computed over a fixed-size
+// sliding window of bytes. Unlike RollingHash, adding and removing a byte
+// are both a single rotate/XOR rather than a modular multiplication, which
+// makes it cheap to evaluate at every byte offset of a file. This is what
+// the content-defined chunker uses to locate chunk boundaries.
+type Buzhash struct {
+	window int
+	buf    []byte
+	pos    int
+	filled bool
+	hash   uint32
+}
+
+// NewBuzhash creates a Buzhash rolling hash over a window of the given size.
+func NewBuzhash(window int) *Buzhash {
+	return &Buzhash{
+		window: window,
+		buf:    make([]byte, window),
+	}
+}
+
+// Reset clears the hash and window so the instance can be reused.
+func (b *Buzhash) Reset() {
+	b.pos = 0
+	b.filled = false
+	b.hash = 0
+	for i := range b.buf {
+		b.buf[i] = 0
+	}
+}
+
+// Roll feeds the next byte into the window, evicting the oldest byte once
+// the window has filled up, and returns the updated hash.
+func (b *Buzhash) Roll(next byte) uint32 {
+	wasFilled := b.filled
+	out := b.buf[b.pos]
+	b.buf[b.pos] = next
+	b.pos++
+	if b.pos == b.window {
+		b.pos = 0
+		b.filled = true
+	}
+
+	if !wasFilled {
+		b.hash = rol32(b.hash, 1) ^ buzTable[next]
+		return b.hash
+	}
+
+	b.hash = rol32(b.hash, 1) ^ rol32(buzTable[out], uint(b.window%32)) ^ buzTable[next]
+	return b.hash
+}
+
+// Sum returns the current hash value without modifying the window.
+func (b *Buzhash) Sum() uint32 {
+	return b.hash
+}
+
+func rol32(x uint32, n uint) uint32 {
+	return (x << n) | (x >> (32 - n))
+}
@@ -0,0 +1,45 @@
+package rollinghash
+
+import "testing"
+
+// TestBuzhashDeterministic ensures that hashing the same window of bytes
+// twice produces the same value.
+func TestBuzhashDeterministic(t *testing.T) {
+	data := []byte("the quick brown fox jumps")
+
+	first := NewBuzhash(len(data))
+	for _, b := range data {
+		first.Roll(b)
+	}
+
+	second := NewBuzhash(len(data))
+	for _, b := range data {
+		second.Roll(b)
+	}
+
+	if first.Sum() != second.Sum() {
+		t.Errorf("expected identical hashes for identical input, got %d and %d", first.Sum(), second.Sum())
+	}
+}
+
+// TestBuzhashRollsWindow verifies that once the window is full, the oldest
+// byte stops influencing the hash: rolling the same trailing bytes through
+// two different histories converges to the same value.
+func TestBuzhashRollsWindow(t *testing.T) {
+	window := 4
+	tail := []byte("abcd")
+
+	bz := NewBuzhash(window)
+	for _, b := range []byte("XYZQabcd") {
+		bz.Roll(b)
+	}
+
+	reference := NewBuzhash(window)
+	for _, b := range tail {
+		reference.Roll(b)
+	}
+
+	if bz.Sum() != reference.Sum() {
+		t.Errorf("expected hash to depend only on the last %d bytes, got %d want %d", window, bz.Sum(), reference.Sum())
+	}
+}